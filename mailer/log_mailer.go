@@ -0,0 +1,19 @@
+package mailer
+
+import "log"
+
+// LogMailer writes intended sends to a logger instead of actually sending
+// them, so a developer running the service locally can see what would have
+// gone out without needing SMTP credentials
+type LogMailer struct {
+	logger *log.Logger
+}
+
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.logger.Printf("mail: to=%q subject=%q body=%q", to, subject, body)
+	return nil
+}
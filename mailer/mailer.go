@@ -0,0 +1,8 @@
+package mailer
+
+// Mailer abstracts the outgoing transport so the worker doesn't need to know
+// whether it's talking to a real SMTP server, logging to stdout for local
+// dev, or dropping everything on the floor in tests
+type Mailer interface {
+	Send(to, subject, body string) error
+}
@@ -0,0 +1,13 @@
+package mailer
+
+// NullMailer drops every message it's given. It exists so tests can wire up
+// a worker without ever touching a network socket
+type NullMailer struct{}
+
+func NewNullMailer() *NullMailer {
+	return &NullMailer{}
+}
+
+func (m *NullMailer) Send(to, subject, body string) error {
+	return nil
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// SMTPMailer sends through a real SMTP server, upgrading to STARTTLS when the
+// server offers it and authenticating with PLAIN auth
+type SMTPMailer struct {
+	addr string
+	user string
+	pass string
+}
+
+func NewSMTPMailer(host, port, user, pass string) *SMTPMailer {
+	return &SMTPMailer{
+		addr: host + ":" + port,
+		user: user,
+		pass: pass,
+	}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	auth := sasl.NewPlainClient("", m.user, m.pass)
+
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.user, stripCRLF(to), stripCRLF(subject), body,
+	)
+
+	return smtp.SendMail(m.addr, auth, m.user, []string{to}, strings.NewReader(msg))
+}
+
+// stripCRLF removes carriage returns and newlines so a malicious "To" or
+// "Subject" value can't smuggle extra headers (e.g. a Bcc) into the message
+// we hand to the SMTP server
+func stripCRLF(s string) string {
+	s = strings.ReplaceAll(s, "\r", "")
+	return strings.ReplaceAll(s, "\n", "")
+}
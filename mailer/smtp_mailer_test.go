@@ -0,0 +1,14 @@
+package mailer
+
+import "testing"
+
+// regression test for the SMTP header-injection fix: a malicious To/Subject
+// must never be able to smuggle an extra header into the message
+func TestStripCRLF(t *testing.T) {
+	in := "evil@example.com\r\nBcc: attacker@evil.com\nX-Injected: yes"
+	want := "evil@example.comBcc: attacker@evil.comX-Injected: yes"
+
+	if got := stripCRLF(in); got != want {
+		t.Fatalf("stripCRLF(%q) = %q, want %q", in, got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/emersion/go-smtp"
+
+	"github.com/obanoff/Mailinglist-microservice/mdb"
+)
+
+// linkData is what the body template is rendered with for each recipient
+type linkData struct {
+	ConfirmURL string
+	UnsubURL   string
+}
+
+// Worker streams every non-opted-out subscriber out of a Store, renders the
+// body template with that subscriber's confirm/unsubscribe links, and sends
+// it through a Mailer, retrying transient failures with exponential backoff
+type Worker struct {
+	store          mdb.Store
+	mailer         Mailer
+	subject        string
+	body           *template.Template
+	confirmBaseURL string
+	unsubBaseURL   string
+	maxRetries     int
+	retryBackoff   time.Duration
+}
+
+func NewWorker(store mdb.Store, m Mailer, subject, body, confirmBaseURL, unsubBaseURL string) (*Worker, error) {
+	tmpl, err := template.New("mail").Parse(body)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &Worker{
+		store:          store,
+		mailer:         m,
+		subject:        subject,
+		body:           tmpl,
+		confirmBaseURL: confirmBaseURL,
+		unsubBaseURL:   unsubBaseURL,
+		maxRetries:     3,
+		retryBackoff:   time.Second,
+	}, nil
+}
+
+// Run walks every non-opted-out subscriber exactly once, sending one mail
+// each
+func (w *Worker) Run() error {
+	it, err := w.store.GetAllEmails()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer it.Close()
+
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		if err := w.sendWithRetry(*entry); err != nil {
+			// one bad recipient shouldn't stop the rest of the run
+			log.Println(err)
+		}
+	}
+}
+
+func (w *Worker) sendWithRetry(entry mdb.EmailEntry) error {
+	var buf bytes.Buffer
+	data := linkData{
+		ConfirmURL: w.confirmBaseURL + entry.SubToken,
+		UnsubURL:   w.unsubBaseURL + entry.UnsubToken,
+	}
+	if err := w.body.Execute(&buf, data); err != nil {
+		return err
+	}
+	body := buf.String()
+
+	backoff := w.retryBackoff
+	var err error
+	for attempt := 0; attempt <= w.maxRetries; attempt++ {
+		if err = w.mailer.Send(entry.Email, w.subject, body); err == nil {
+			return nil
+		}
+
+		if isPermanent(err) {
+			log.Printf("mailer: send to %s failed permanently, not retrying: %v", entry.Email, err)
+			return err
+		}
+
+		log.Printf("mailer: send to %s failed (attempt %d/%d): %v", entry.Email, attempt+1, w.maxRetries+1, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return err
+}
+
+// isPermanent reports whether err is a 5xx SMTP response, which will never
+// succeed on retry (a bad recipient, a rejected sender, etc.), as opposed to
+// a 4xx or a transport-level error, which is worth retrying
+func isPermanent(err error) bool {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 500
+	}
+	return false
+}
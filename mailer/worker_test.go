@@ -0,0 +1,132 @@
+package mailer
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+
+	"github.com/obanoff/Mailinglist-microservice/mdb"
+)
+
+func openTestStore(t *testing.T) *mdb.SQLiteStore {
+	t.Helper()
+
+	store, err := mdb.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("mdb.Open() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func newTestWorker(t *testing.T, store mdb.Store, m Mailer) *Worker {
+	t.Helper()
+
+	w, err := NewWorker(store, m, "subject", "confirm: {{.ConfirmURL}} unsub: {{.UnsubURL}}", "https://example.com/confirm?token=", "https://example.com/unsub?token=")
+	if err != nil {
+		t.Fatalf("NewWorker() error = %v", err)
+	}
+
+	// tests don't want to sit through the real second-scale backoff
+	w.retryBackoff = time.Millisecond
+
+	return w
+}
+
+type recordingMailer struct {
+	sent []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, to)
+	return nil
+}
+
+// mdb rejects a CR/LF-embedded or display-name-qualified address at every
+// write path, so an attempt to smuggle one in never reaches the store, and
+// the worker streaming over that store never hands it to a Mailer either
+func TestWorker_SkipsAddressesRejectedByStore(t *testing.T) {
+	store := openTestStore(t)
+
+	if err := store.CreateEmail("ok@example.com"); err != nil {
+		t.Fatalf("CreateEmail(ok) error = %v", err)
+	}
+
+	if err := store.CreateEmail("evil@example.com\r\nBcc: attacker@evil.com"); err == nil {
+		t.Fatal("CreateEmail(evil) error = nil, want rejection of CR/LF-embedded address")
+	}
+
+	m := &recordingMailer{}
+	w := newTestWorker(t, store, m)
+
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(m.sent) != 1 || m.sent[0] != "ok@example.com" {
+		t.Fatalf("sent = %v, want exactly [ok@example.com]", m.sent)
+	}
+}
+
+type flakyMailer struct {
+	calls     int
+	failTimes int
+}
+
+func (m *flakyMailer) Send(to, subject, body string) error {
+	m.calls++
+	if m.calls <= m.failTimes {
+		return errors.New("transient: connection reset")
+	}
+	return nil
+}
+
+func TestWorker_RetriesTransientFailures(t *testing.T) {
+	store := openTestStore(t)
+	if err := store.CreateEmail("ok@example.com"); err != nil {
+		t.Fatalf("CreateEmail() error = %v", err)
+	}
+
+	m := &flakyMailer{failTimes: 2}
+	w := newTestWorker(t, store, m)
+
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if m.calls != 3 {
+		t.Fatalf("calls = %d, want 3 (2 failures + 1 success)", m.calls)
+	}
+}
+
+type countingMailer struct {
+	calls int
+	err   error
+}
+
+func (m *countingMailer) Send(to, subject, body string) error {
+	m.calls++
+	return m.err
+}
+
+func TestWorker_DoesNotRetryPermanentFailures(t *testing.T) {
+	store := openTestStore(t)
+	if err := store.CreateEmail("ok@example.com"); err != nil {
+		t.Fatalf("CreateEmail() error = %v", err)
+	}
+
+	m := &countingMailer{err: &smtp.SMTPError{Code: 550, Message: "no such user"}}
+	w := newTestWorker(t, store, m)
+
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if m.calls != 1 {
+		t.Fatalf("calls = %d, want 1 (a 5xx should not be retried)", m.calls)
+	}
+}
@@ -1,187 +1,91 @@
 package mdb
 
 import (
-	"database/sql"
-	"log"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
 	"time"
-
-	"github.com/mattn/go-sqlite3"
 )
 
+// ErrNotFound is returned by the lookup functions below instead of (nil, nil)
+// so callers can tell "no such row" apart from a real DB error.
+var ErrNotFound = errors.New("mdb: no such email entry")
+
 // represent db row
 type EmailEntry struct {
 	Id          int64
 	Email       string
 	ConfirmedAt *time.Time
 	OptOut      bool
+	SubToken    string
+	UnsubToken  string
+	CreatedAt   *time.Time
 }
 
-func TryCreate(db *sql.DB) {
-	// sqlite provides auto id for PRIMARY KEY ; it doesn't have appropriate date types so using integer type converting date to unix
-	_, err := db.Exec(`
-		CREATE TABLE emails (
-			id 				INTEGER PRIMARY KEY,
-			email 			TEXT UNIQUE,
-			confirmed_at 	INTEGER,
-			opt_out			INTEGER
-		);
-	`)
-	if err != nil {
-		// casting error type back to sqlite3.Error using syntax err.()
-		// we need this to separate error 'table arleady exists'(this thing doens't need to be handled) from others
-		if sqlError, ok := err.(sqlite3.Error); ok {
-			// error code 1 means 'table already exists'
-			if sqlError.Code != 1 {
-				log.Fatal(sqlError)
-			}
-			// handle all other errors
-		} else {
-			log.Fatal(err)
-		}
-	}
-}
-
-// creating EmailEntry structure from the DB row
-func emailEntryFromRow(row *sql.Rows) (*EmailEntry, error) {
-	var (
-		id          int64
-		email       string
-		confirmedAt int64
-		optOut      bool
-	)
-
-	// scan should be in the same order as columns appear in DB
-	err := row.Scan(&id, &email, &confirmedAt, &optOut)
-
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	// convert the time to appropriate time structure (from seconds to time string)
-	t := time.Unix(confirmedAt, 0)
-
-	return &EmailEntry{
-		Id:          id,
-		Email:       email,
-		ConfirmedAt: &t,
-		OptOut:      optOut,
-	}, nil
+// Represents a number of page and number of emails per page
+type GetEmailBatchQueryParams struct {
+	Page  int
+	Count int
 }
 
-// CRUD implementation
-
-// CREATE
-func CreateEmail(db *sql.DB, email string) error {
-	_, err := db.Exec(`
-		INSERT INTO emails(email, confirmed_at, opt_out)
-		VALUES (
-			(?, 0, false)
-		);
-	`, email) // email will be substituted for the question mark
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	return nil
+// EmailIterator walks a result set one row at a time, returning io.EOF once
+// exhausted, so a caller can stream through every subscriber with bounded
+// memory instead of paging with LIMIT/OFFSET. Close releases the underlying
+// cursor early, for a caller that stops before reaching io.EOF; Next closes
+// it automatically once exhausted or once it hits an error.
+type EmailIterator interface {
+	Next() (*EmailEntry, error)
+	Close() error
 }
 
-// READ
-func GetEmail(db *sql.DB, email string) (*EmailEntry, error) {
-	rows, err := db.Query(`
-		SELECT * FROM emails
-		WHERE email = ?;
-	`, email)
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-	// unlike db.Exec, db.Query proceeds reading until closed
-	defer rows.Close()
-
-	// Next() prepares next row to be read by scan() and returns true if it's existing or false if no rows left
-	for rows.Next() {
-		return emailEntryFromRow(rows)
-	}
-
-	// such an email is not existing
-	return nil, nil
+// Store is the CRUD surface the rest of the service talks to, kept separate
+// from any particular backend so tests (and, later, a Postgres or in-memory
+// implementation) can plug in instead of a real SQLite file
+type Store interface {
+	CreateEmail(email string) error
+	CreateEmails(emails []string) error
+	GetEmail(email string) (*EmailEntry, error)
+	GetBySubToken(token string) (*EmailEntry, error)
+	GetByUnsubToken(token string) (*EmailEntry, error)
+	UpdateEmail(entry EmailEntry) error
+	UpsertEmails(entries []EmailEntry) error
+	DeleteEmail(email string) error
+	GetEmailBatch(params GetEmailBatchQueryParams) ([]EmailEntry, error)
+	GetAllEmails() (EmailIterator, error)
 }
 
-// UPSERT (INSERT or UPDATE) with hepl of ON CONFLICT target action
-func UpdateEmail(db *sql.DB, entry EmailEntry) error {
-	// convert time.Time to int64
-	t := entry.ConfirmedAt.Unix()
-
-	_, err := db.Exec(`
-		INSERT INTO emails(email, confirmed_at, opt_out)
-		VALUES (?, ?, ?)
-		ON CONFLICT(email) DO UPDATE
-		SET
-			confirmed_at = ?,
-			opt_out = ?;
-	`, entry.Email, t, entry.OptOut, t, entry.OptOut)
+// validateEmail rejects anything that isn't a single well-formed RFC 5322
+// address, which also rules out the embedded CR/LF a header-injection
+// attempt would need to smuggle extra headers (e.g. a Bcc) into outgoing
+// mail. mail.ParseAddress alone would accept a display-name-qualified form
+// like "Foo <a@b.com>", so this also requires the input to already be the
+// bare address it parses out to, instead of silently storing the longer form.
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 
-	return nil
-}
-
-// DELETE
-func DeleteEmail(db *sql.DB, email string) error {
-	// In this specific case, deleting an email will be considered as an unsent email and app will send it again, spamming mail boxes. To avoid that behavior, I just update opt_out to true
-	_, err := db.Exec(`
-		UPDATE emails
-		SET opt_out = true
-		WHERE email = ?;
-	`, email)
-	if err != nil {
-		log.Println(err)
-		return err
+	if addr.Address != email {
+		return fmt.Errorf("mdb: %q is not a bare email address", email)
 	}
 
 	return nil
 }
 
-// Pagination functionality
-
-// Represents a number of page and number of emails per page
-type GetEmailBatchQueryParams struct {
-	Page  int
-	Count int
-}
-
-func GetEmailBatch(db *sql.DB, params GetEmailBatchQueryParams) ([]EmailEntry, error) {
-	var empty []EmailEntry
-
-	// Limit number of rows to params.Count and skip the first rows. params.Page - 1 needed to not skip result from the start
-	rows, err := db.Query(`
-		SELECT * FROM emails
-		WHERE opt_out = false
-		ORDER BY id ASC
-		LIMIT ?
-		OFFSET ?
-	`, params.Count, (params.Page-1)*params.Count)
-	if err != nil {
-		log.Println(err)
-		return empty, err
+// generateToken returns a URL-safe base64 encoding of the SHA-512 digest of
+// random bytes, suitable for putting in a confirm/unsubscribe link without
+// leaking the email address itself
+func generateToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
 	}
 
-	defer rows.Close()
-
-	emails := make([]EmailEntry, 0, params.Count)
-
-	for rows.Next() {
-		entry, err := emailEntryFromRow(rows)
-		if err != nil {
-			return nil, err
-		}
-
-		emails = append(emails, *entry)
-	}
+	sum := sha512.Sum512(raw)
 
-	return emails, nil
+	return base64.URLEncoding.EncodeToString(sum[:]), nil
 }
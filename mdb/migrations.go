@@ -0,0 +1,42 @@
+package mdb
+
+import migrate "github.com/rubenv/sql-migrate"
+
+// migrations is the ordered, append-only history of the emails schema.
+// sql-migrate records which ids have already been applied in a
+// schema_migrations table, so Open can run this on every startup and only
+// the new entries at the bottom ever actually execute.
+var migrations = &migrate.MemoryMigrationSource{
+	Migrations: []*migrate.Migration{
+		{
+			Id: "001_create_emails",
+			Up: []string{`
+				CREATE TABLE emails (
+					id 				INTEGER PRIMARY KEY,
+					email 			TEXT UNIQUE,
+					confirmed_at 	INTEGER,
+					opt_out			INTEGER
+				);
+			`},
+			Down: []string{`DROP TABLE emails;`},
+		},
+		{
+			Id: "002_add_tokens_and_created_at",
+			Up: []string{
+				`ALTER TABLE emails ADD COLUMN sub_token TEXT;`,
+				`ALTER TABLE emails ADD COLUMN unsub_token TEXT;`,
+				`ALTER TABLE emails ADD COLUMN created_at INTEGER;`,
+			},
+			// sqlite can't drop columns without rebuilding the table, so the
+			// down migration just blanks them back out
+			Down: []string{
+				`UPDATE emails SET sub_token = NULL, unsub_token = NULL, created_at = NULL;`,
+			},
+		},
+		{
+			Id:   "003_index_opt_out",
+			Up:   []string{`CREATE INDEX idx_emails_opt_out ON emails(opt_out);`},
+			Down: []string{`DROP INDEX idx_emails_opt_out;`},
+		},
+	},
+}
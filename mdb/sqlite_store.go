@@ -0,0 +1,453 @@
+package mdb
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore is the SQLite-backed Store implementation used in production
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// Open opens (creating if necessary) the sqlite3 file at dataSourceName and
+// brings its schema up to date via the migrations in migrations.go, replacing
+// the old "CREATE TABLE, ignore error code 1" dance with something that can
+// actually evolve the schema without dropping data
+func Open(dataSourceName string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", dataSourceName)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	migrate.SetTable("schema_migrations")
+	if _, err := migrate.Exec(db, "sqlite3", migrations, migrate.Up); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Close releases the underlying *sql.DB
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// creating EmailEntry structure from the DB row
+func emailEntryFromRow(row *sql.Rows) (*EmailEntry, error) {
+	var (
+		id          int64
+		email       string
+		confirmedAt int64
+		optOut      bool
+		subToken    string
+		unsubToken  string
+		createdAt   int64
+	)
+
+	// scan should be in the same order as columns appear in DB
+	err := row.Scan(&id, &email, &confirmedAt, &optOut, &subToken, &unsubToken, &createdAt)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	// convert the time to appropriate time structure (from seconds to time string)
+	cAt := time.Unix(confirmedAt, 0)
+	crAt := time.Unix(createdAt, 0)
+
+	return &EmailEntry{
+		Id:          id,
+		Email:       email,
+		ConfirmedAt: &cAt,
+		OptOut:      optOut,
+		SubToken:    subToken,
+		UnsubToken:  unsubToken,
+		CreatedAt:   &crAt,
+	}, nil
+}
+
+// CRUD implementation
+
+// CREATE
+func (s *SQLiteStore) CreateEmail(email string) error {
+	if err := validateEmail(email); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	subToken, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	unsubToken, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO emails(email, confirmed_at, opt_out, sub_token, unsub_token, created_at)
+		VALUES (?, 0, false, ?, ?, ?);
+	`, email, subToken, unsubToken, time.Now().Unix()) // email and tokens will be substituted for the question marks
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateEmails bulk-inserts emails in a single transaction with a prepared
+// statement, so importing an existing list of tens of thousands of addresses
+// completes in seconds and is atomic on a real failure. A duplicate address
+// already in the table (or repeated within the batch) is just skipped,
+// rather than aborting the whole import the way a plain INSERT would.
+func (s *SQLiteStore) CreateEmails(emails []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO emails(email, confirmed_at, opt_out, sub_token, unsub_token, created_at)
+		VALUES (?, 0, false, ?, ?, ?)
+		ON CONFLICT(email) DO NOTHING;
+	`)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+
+	for _, email := range emails {
+		if err := validateEmail(email); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+
+		subToken, err := generateToken()
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+
+		unsubToken, err := generateToken()
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := stmt.Exec(email, subToken, unsubToken, now); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// READ
+func (s *SQLiteStore) GetEmail(email string) (*EmailEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT * FROM emails
+		WHERE email = ?;
+	`, email)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	// unlike db.Exec, db.Query proceeds reading until closed
+	defer rows.Close()
+
+	// Next() prepares next row to be read by scan() and returns true if it's existing or false if no rows left
+	for rows.Next() {
+		return emailEntryFromRow(rows)
+	}
+
+	// such an email is not existing
+	return nil, ErrNotFound
+}
+
+// GetBySubToken looks up the row whose sub_token matches, so a confirm-link
+// handler can look up the subscriber without the raw email ever appearing in
+// the URL
+func (s *SQLiteStore) GetBySubToken(token string) (*EmailEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT * FROM emails
+		WHERE sub_token = ?;
+	`, token)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return emailEntryFromRow(rows)
+	}
+
+	return nil, ErrNotFound
+}
+
+// GetByUnsubToken looks up the row whose unsub_token matches, so an
+// unsubscribe-link handler can look up the subscriber without the raw email
+// ever appearing in the URL
+func (s *SQLiteStore) GetByUnsubToken(token string) (*EmailEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT * FROM emails
+		WHERE unsub_token = ?;
+	`, token)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return emailEntryFromRow(rows)
+	}
+
+	return nil, ErrNotFound
+}
+
+// UPSERT (INSERT or UPDATE) with hepl of ON CONFLICT target action
+func (s *SQLiteStore) UpdateEmail(entry EmailEntry) error {
+	if err := validateEmail(entry.Email); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	// convert time.Time to int64
+	t := confirmedAtUnix(entry)
+
+	// the INSERT branch only ever fires for an email UpdateEmail has never
+	// seen before, so it needs its own tokens just like CreateEmail; the
+	// ON CONFLICT branch leaves the existing row's tokens untouched
+	subToken, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	unsubToken, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO emails(email, confirmed_at, opt_out, sub_token, unsub_token, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE
+		SET
+			confirmed_at = ?,
+			opt_out = ?;
+	`, entry.Email, t, entry.OptOut, subToken, unsubToken, time.Now().Unix(), t, entry.OptOut)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// confirmedAtUnix converts entry.ConfirmedAt to the unix seconds the emails
+// table stores, defaulting to the zero time for a not-yet-confirmed entry
+// the same way CreateEmail does, instead of panicking on a nil pointer
+func confirmedAtUnix(entry EmailEntry) int64 {
+	if entry.ConfirmedAt == nil {
+		return 0
+	}
+	return entry.ConfirmedAt.Unix()
+}
+
+// UpsertEmails is the batch form of UpdateEmail: it applies every entry in a
+// single transaction with a prepared statement, so a bulk re-import doesn't
+// leave the table half-updated if one of the entries fails
+func (s *SQLiteStore) UpsertEmails(entries []EmailEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO emails(email, confirmed_at, opt_out, sub_token, unsub_token, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE
+		SET
+			confirmed_at = ?,
+			opt_out = ?;
+	`)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+
+	for _, entry := range entries {
+		if err := validateEmail(entry.Email); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+
+		t := confirmedAtUnix(entry)
+
+		// only ever used by the INSERT branch; an UPDATE leaves the
+		// existing row's tokens as they were
+		subToken, err := generateToken()
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+
+		unsubToken, err := generateToken()
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := stmt.Exec(entry.Email, t, entry.OptOut, subToken, unsubToken, now, t, entry.OptOut); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DELETE
+func (s *SQLiteStore) DeleteEmail(email string) error {
+	// In this specific case, deleting an email will be considered as an unsent email and app will send it again, spamming mail boxes. To avoid that behavior, I just update opt_out to true
+	_, err := s.db.Exec(`
+		UPDATE emails
+		SET opt_out = true
+		WHERE email = ?;
+	`, email)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// Pagination functionality
+
+func (s *SQLiteStore) GetEmailBatch(params GetEmailBatchQueryParams) ([]EmailEntry, error) {
+	var empty []EmailEntry
+
+	// Limit number of rows to params.Count and skip the first rows. params.Page - 1 needed to not skip result from the start
+	rows, err := s.db.Query(`
+		SELECT * FROM emails
+		WHERE opt_out = false
+		ORDER BY id ASC
+		LIMIT ?
+		OFFSET ?
+	`, params.Count, (params.Page-1)*params.Count)
+	if err != nil {
+		log.Println(err)
+		return empty, err
+	}
+
+	defer rows.Close()
+
+	emails := make([]EmailEntry, 0, params.Count)
+
+	for rows.Next() {
+		entry, err := emailEntryFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, *entry)
+	}
+
+	return emails, nil
+}
+
+// sqliteEmailIterator walks a *sql.Rows one row at a time and makes sure the
+// cursor is released exactly once, whether that's because the rows were
+// exhausted, a Scan failed partway through, or the caller bailed out early
+// via Close
+type sqliteEmailIterator struct {
+	rows   *sql.Rows
+	closed bool
+}
+
+func (it *sqliteEmailIterator) Next() (*EmailEntry, error) {
+	if it.closed {
+		return nil, io.EOF
+	}
+
+	if !it.rows.Next() {
+		err := it.rows.Err()
+		it.Close()
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	entry, err := emailEntryFromRow(it.rows)
+	if err != nil {
+		it.Close()
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (it *sqliteEmailIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	return it.rows.Close()
+}
+
+// GetAllEmails walks every non-opted-out row without ever holding more than
+// one of them in memory at a time
+func (s *SQLiteStore) GetAllEmails() (EmailIterator, error) {
+	rows, err := s.db.Query(`
+		SELECT * FROM emails
+		WHERE opt_out = false
+		ORDER BY id ASC;
+	`)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &sqliteEmailIterator{rows: rows}, nil
+}
@@ -0,0 +1,113 @@
+package mdb
+
+import (
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	store, err := Open("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// regression test for the malformed "VALUES ((?, 0, false))" INSERT, which
+// SQLite parsed as a row-value expression and failed at runtime
+func TestCreateEmail(t *testing.T) {
+	store := openTestStore(t)
+
+	if err := store.CreateEmail("new@example.com"); err != nil {
+		t.Fatalf("CreateEmail() error = %v", err)
+	}
+
+	entry, err := store.GetEmail("new@example.com")
+	if err != nil {
+		t.Fatalf("GetEmail() error = %v", err)
+	}
+
+	if entry.SubToken == "" || entry.UnsubToken == "" {
+		t.Fatalf("GetEmail() returned entry with empty tokens: %+v", entry)
+	}
+}
+
+// a display-name-qualified address must be rejected rather than silently
+// stored verbatim, since that form isn't a valid SMTP envelope recipient
+func TestCreateEmail_RejectsDisplayName(t *testing.T) {
+	store := openTestStore(t)
+
+	if err := store.CreateEmail("Foo <foo@example.com>"); err == nil {
+		t.Fatal("CreateEmail() error = nil, want error for display-name-qualified address")
+	}
+}
+
+// a duplicate address anywhere in the batch must not roll back the rest of
+// the import
+func TestCreateEmails_SkipsDuplicates(t *testing.T) {
+	store := openTestStore(t)
+
+	err := store.CreateEmails([]string{"dup@example.com", "dup@example.com", "ok@example.com"})
+	if err != nil {
+		t.Fatalf("CreateEmails() error = %v", err)
+	}
+
+	if _, err := store.GetEmail("dup@example.com"); err != nil {
+		t.Errorf("GetEmail(dup) error = %v", err)
+	}
+
+	if _, err := store.GetEmail("ok@example.com"); err != nil {
+		t.Errorf("GetEmail(ok) error = %v", err)
+	}
+}
+
+// a freshly imported, not-yet-confirmed entry has a nil ConfirmedAt; it must
+// neither panic nor leave the row unreadable afterwards
+func TestUpsertEmails_NilConfirmedAt(t *testing.T) {
+	store := openTestStore(t)
+
+	err := store.UpsertEmails([]EmailEntry{{Email: "new@example.com"}})
+	if err != nil {
+		t.Fatalf("UpsertEmails() error = %v", err)
+	}
+
+	entry, err := store.GetEmail("new@example.com")
+	if err != nil {
+		t.Fatalf("GetEmail() error = %v", err)
+	}
+
+	if entry.SubToken == "" || entry.UnsubToken == "" {
+		t.Fatalf("GetEmail() returned entry with empty tokens: %+v", entry)
+	}
+}
+
+// UpdateEmail and UpsertEmails are reachable from callers that never went
+// through Create*, so they need the same CR/LF and display-name rejection
+func TestUpdateEmail_RejectsDisplayName(t *testing.T) {
+	store := openTestStore(t)
+
+	now := time.Now()
+	entry := EmailEntry{Email: "Foo <foo@example.com>", ConfirmedAt: &now}
+
+	if err := store.UpdateEmail(entry); err == nil {
+		t.Fatal("UpdateEmail() error = nil, want error for display-name-qualified address")
+	}
+}
+
+func TestUpsertEmails_RejectsCRLF(t *testing.T) {
+	store := openTestStore(t)
+
+	entries := []EmailEntry{{Email: "evil@example.com\r\nBcc: attacker@evil.com"}}
+
+	if err := store.UpsertEmails(entries); err == nil {
+		t.Fatal("UpsertEmails() error = nil, want error for CR/LF-embedded address")
+	}
+
+	if _, err := store.GetEmail("evil@example.com\r\nBcc: attacker@evil.com"); err != ErrNotFound {
+		t.Fatalf("GetEmail() error = %v, want ErrNotFound (row should not have been inserted)", err)
+	}
+}